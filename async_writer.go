@@ -0,0 +1,235 @@
+package monthlyrotate
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+var _ io.Writer = (*AsyncWriter)(nil)
+var _ io.Closer = (*AsyncWriter)(nil)
+
+// errAsyncWriterTimeout is returned by AsyncWriter.Write when WriteTimeout
+// elapses before there's room in the queue.
+var errAsyncWriterTimeout = errors.New("monthlyrotate: AsyncWriter: write timed out waiting for queue space")
+
+// errAsyncWriterClosed is returned by AsyncWriter.Write once Close has been
+// called.
+var errAsyncWriterClosed = errors.New("monthlyrotate: AsyncWriter: writer is closed")
+
+// AsyncWriterConfig configures an AsyncWriter.
+type AsyncWriterConfig struct {
+	// QueueSize is the number of pending writes buffered before
+	// WriteTimeout/DropOnFull kicks in. Defaults to 1024.
+	QueueSize int
+
+	// WriteTimeout is the longest Write will block waiting for room in the
+	// queue. Zero means no limit: Write blocks until there's room, or, with
+	// DropOnFull, drops the message at once.
+	WriteTimeout time.Duration
+
+	// DropOnFull, if true, makes Write silently drop the message instead of
+	// returning an error when the queue is full: at once if WriteTimeout is
+	// zero, otherwise once WriteTimeout elapses without room. If false,
+	// Write blocks, up to WriteTimeout if set, until there's room.
+	DropOnFull bool
+
+	// RetryBackoff is the initial delay between retries of a failed write to
+	// the underlying File; it doubles on each consecutive failure, up to
+	// RetryMaxBackoff. Defaults to 100ms.
+	RetryBackoff time.Duration
+
+	// RetryMaxBackoff caps RetryBackoff's growth. Defaults to 10s.
+	RetryMaxBackoff time.Duration
+
+	// MaxRetries caps how many times a failing write to the underlying File
+	// is retried before it's given up on and dropped. Zero (the default)
+	// means retry indefinitely; either way, Close still interrupts a
+	// pending retry so shutdown isn't blocked by a permanently broken file.
+	MaxRetries int
+
+	// OnError, if set, is called from the background goroutine whenever a
+	// write to the underlying File fails, including ones later retried
+	// successfully.
+	OnError func(error)
+}
+
+// AsyncWriter wraps a *File with a bounded queue of messages and a
+// dedicated goroutine that drains them into the file, so that Write never
+// pays the cost of opening, rotating, or flushing the underlying file. It's
+// meant to be plugged straight into log.New or a logging library's
+// io.Writer core without every caller re-implementing a mutex-protected
+// buffered pipe.
+type AsyncWriter struct {
+	file *File
+	cfg  AsyncWriterConfig
+
+	queue chan []byte
+	flush chan chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+	wg    sync.WaitGroup
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewAsyncWriter creates an AsyncWriter that writes to file in the
+// background, according to cfg.
+func NewAsyncWriter(file *File, cfg AsyncWriterConfig) *AsyncWriter {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1024
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = 100 * time.Millisecond
+	}
+	if cfg.RetryMaxBackoff <= 0 {
+		cfg.RetryMaxBackoff = 10 * time.Second
+	}
+
+	w := &AsyncWriter{
+		file:  file,
+		cfg:   cfg,
+		queue: make(chan []byte, cfg.QueueSize),
+		flush: make(chan chan struct{}),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	w.wg.Add(1)
+	go w.run()
+	return w
+}
+
+// Write queues d to be written to the underlying File. It copies d before
+// returning, so the caller's slice can be reused immediately. If
+// cfg.WriteTimeout is set, it never blocks longer than that; once the queue
+// is full it either blocks or drops d, per cfg.DropOnFull. Once Close has been called, Write
+// returns an error instead of queuing.
+func (w *AsyncWriter) Write(d []byte) (int, error) {
+	select {
+	case <-w.stop:
+		return 0, errAsyncWriterClosed
+	default:
+	}
+
+	msg := append([]byte(nil), d...)
+
+	select {
+	case w.queue <- msg:
+		return len(d), nil
+	default:
+	}
+
+	if w.cfg.DropOnFull && w.cfg.WriteTimeout <= 0 {
+		return len(d), nil
+	}
+
+	var timeout <-chan time.Time
+	if w.cfg.WriteTimeout > 0 {
+		timer := time.NewTimer(w.cfg.WriteTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case w.queue <- msg:
+		return len(d), nil
+	case <-w.stop:
+		return 0, errAsyncWriterClosed
+	case <-timeout:
+		if w.cfg.DropOnFull {
+			return len(d), nil
+		}
+		return 0, errAsyncWriterTimeout
+	}
+}
+
+// Flush blocks until every message queued before the call has been written
+// to the underlying File, then flushes it.
+func (w *AsyncWriter) Flush() error {
+	ack := make(chan struct{})
+	select {
+	case w.flush <- ack:
+		<-ack
+	case <-w.done:
+		return nil
+	}
+	return w.file.Flush()
+}
+
+// Close stops accepting new writes, drains the queue (writing everything
+// queued before the call to the underlying File), then closes it. Close is
+// idempotent: calling it more than once just returns the first call's
+// result.
+func (w *AsyncWriter) Close() error {
+	w.closeOnce.Do(func() {
+		close(w.stop)
+		w.wg.Wait()
+		close(w.done)
+		w.closeErr = w.file.Close()
+	})
+	return w.closeErr
+}
+
+func (w *AsyncWriter) run() {
+	defer w.wg.Done()
+	backoff := w.cfg.RetryBackoff
+	for {
+		select {
+		case d := <-w.queue:
+			backoff = w.writeWithRetry(d, backoff)
+		case ack := <-w.flush:
+			w.drainQueue()
+			close(ack)
+		case <-w.stop:
+			w.drainQueue()
+			return
+		}
+	}
+}
+
+// drainQueue writes every message currently buffered in the queue, without
+// blocking for more to arrive.
+func (w *AsyncWriter) drainQueue() {
+	for {
+		select {
+		case d := <-w.queue:
+			w.writeWithRetry(d, w.cfg.RetryBackoff)
+		default:
+			return
+		}
+	}
+}
+
+// writeWithRetry writes d to the underlying File, retrying with exponential
+// backoff (starting at backoff, capped at RetryMaxBackoff) until it
+// succeeds, cfg.MaxRetries is exhausted, or the writer is closed. It returns
+// the backoff to use for the next failing write.
+func (w *AsyncWriter) writeWithRetry(d []byte, backoff time.Duration) time.Duration {
+	for attempt := 0; ; attempt++ {
+		_, err := w.file.Write(d)
+		if err == nil {
+			return w.cfg.RetryBackoff
+		}
+		if w.cfg.OnError != nil {
+			w.cfg.OnError(err)
+		}
+		if w.cfg.MaxRetries > 0 && attempt >= w.cfg.MaxRetries {
+			return w.cfg.RetryBackoff
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-w.stop:
+			timer.Stop()
+			return w.cfg.RetryBackoff
+		}
+
+		backoff *= 2
+		if backoff > w.cfg.RetryMaxBackoff {
+			backoff = w.cfg.RetryMaxBackoff
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package monthlyrotate
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// scheduleCleanup runs retention cleanup for a just-rotated file in the
+// background so it doesn't block writes. If Compress is set, oldPath is
+// gzipped first. Errors are reported on CleanupErrors rather than returned.
+//
+// scheduleCleanup must be called with f's lock already held (it's only
+// called from reopenIfNeeded, itself called from write() under f.Lock()),
+// so it reads cfg fields directly instead of re-locking, which would
+// deadlock since sync.Mutex isn't reentrant.
+func (f *File) scheduleCleanup(oldPath string) {
+	compress := f.cfg.Compress
+	errCh := f.cleanupErrors
+
+	go func() {
+		if compress {
+			if err := compressFile(oldPath); err != nil {
+				reportCleanupError(errCh, err)
+			}
+		}
+		if err := f.Cleanup(); err != nil {
+			reportCleanupError(errCh, err)
+		}
+	}()
+}
+
+// CleanupErrors returns a channel on which errors from background retention
+// cleanup (and compression) are reported. Errors are dropped if the channel
+// isn't being drained.
+func (f *File) CleanupErrors() <-chan error {
+	return f.cleanupErrors
+}
+
+// Cleanup deletes rotated files older than MaxAge, keeping at most
+// MaxBackups of them; it doesn't compress anything. It's called
+// automatically in the background after each rotation by the
+// PathIfShouldRotate policy, but not after a MaxSize/MaxLines rotation;
+// callers can also invoke it directly, e.g. on a timer.
+// It's a no-op if PathFormat isn't set or neither MaxAge nor MaxBackups is.
+func (f *File) Cleanup() error {
+	f.Lock()
+	pathFormat := f.cfg.PathFormat
+	maxAge := f.cfg.MaxAge
+	maxBackups := f.cfg.MaxBackups
+	activePath := f.path
+	f.Unlock()
+
+	if pathFormat == "" || (maxAge <= 0 && maxBackups <= 0) {
+		return nil
+	}
+
+	candidates, err := rotatedFiles(pathFormat, activePath)
+	if err != nil {
+		return err
+	}
+	return applyRetention(candidates, maxAge, maxBackups)
+}
+
+// rotatedFile is a file left behind by a previous rotation, matched back to
+// the time it was created.
+type rotatedFile struct {
+	path string
+	time time.Time
+}
+
+// rotatedFiles finds files in the directory of pathFormat that look like
+// they were produced by formatting a time.Time with it (optionally followed
+// by a ".N" numbered-backup suffix and/or a ".gz" compressed suffix),
+// excluding activePath, the currently open file.
+func rotatedFiles(pathFormat, activePath string) ([]rotatedFile, error) {
+	glob := timeFormatGlob(pathFormat)
+	bases, err := filepath.Glob(glob)
+	if err != nil {
+		return nil, err
+	}
+	backups, err := filepath.Glob(glob + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	var out []rotatedFile
+	for _, path := range append(bases, backups...) {
+		if path == activePath {
+			continue
+		}
+		t, err := time.Parse(pathFormat, stripBackupSuffix(path))
+		if err != nil {
+			continue
+		}
+		out = append(out, rotatedFile{path: path, time: t})
+	}
+	return out, nil
+}
+
+// timeFormatGlob converts a time.Format reference-layout pattern into a
+// shell glob that matches any path produced by formatting a time.Time with
+// it, by replacing the reference date/time components with "*".
+func timeFormatGlob(pathFormat string) string {
+	replacer := strings.NewReplacer(
+		"2006", "*", "06", "*",
+		"January", "*", "Jan", "*", "01", "*", "1", "*",
+		"Monday", "*", "Mon", "*", "02", "*", "_2", "*", "2", "*",
+		"15", "*", "03", "*", "3", "*",
+		"04", "*", "4", "*",
+		"05", "*", "5", "*",
+	)
+	glob := replacer.Replace(pathFormat)
+	for strings.Contains(glob, "**") {
+		glob = strings.ReplaceAll(glob, "**", "*")
+	}
+	return glob
+}
+
+// stripBackupSuffix removes a trailing ".gz" and/or ".N" numbered-backup
+// suffix from path, leaving what should be a plain time.Format'd path.
+func stripBackupSuffix(path string) string {
+	path = strings.TrimSuffix(path, ".gz")
+	if idx := strings.LastIndexByte(path, '.'); idx >= 0 {
+		if _, err := strconv.Atoi(path[idx+1:]); err == nil {
+			path = path[:idx]
+		}
+	}
+	return path
+}
+
+// applyRetention deletes candidates older than maxAge or past the newest
+// maxBackups, whichever rule is configured.
+func applyRetention(candidates []rotatedFile, maxAge time.Duration, maxBackups int) error {
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].time.After(candidates[j].time)
+	})
+
+	now := time.Now().UTC()
+	var firstErr error
+	for i, c := range candidates {
+		remove := (maxBackups > 0 && i >= maxBackups) || (maxAge > 0 && now.Sub(c.time) > maxAge)
+		if !remove {
+			continue
+		}
+		if err := os.Remove(c.path); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// reportCleanupError sends err on errCh without blocking if nothing is
+// receiving.
+func reportCleanupError(errCh chan error, err error) {
+	select {
+	case errCh <- err:
+	default:
+	}
+}
+
+// compressFile gzips path to "<path>.gz" and removes path on success.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dstPath := path + ".gz"
+	dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(dst)
+	_, copyErr := io.Copy(gw, src)
+	closeErr := gw.Close()
+	if dstCloseErr := dst.Close(); closeErr == nil {
+		closeErr = dstCloseErr
+	}
+	if copyErr != nil || closeErr != nil {
+		os.Remove(dstPath)
+		if copyErr != nil {
+			return copyErr
+		}
+		return closeErr
+	}
+	return os.Remove(path)
+}
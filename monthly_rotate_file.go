@@ -1,14 +1,17 @@
-// Package monthlyrotate provides a file that is rotated monthly (at midnight UTC).
+// Package monthlyrotate provides a file that is rotated on a configurable
+// schedule (by default monthly, at UTC midnight).
 //
 // You provide a pattern for a file path. That pattern will be formatted with
-// time.Format to generate a real path. It should be unique for each month e.g.
-// 2020-01.txt.
+// time.Format to generate a real path. It should be unique for each rotation
+// period e.g. 2020-01.txt.
 //
 // You Write to a file and the code takes care of closing existing file and
-// opening a new file when we're crossing monthly boundaries.
+// opening a new file when the rotation policy says it's time to rotate.
 package monthlyrotate
 
 import (
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -16,20 +19,109 @@ import (
 	"time"
 )
 
-// File describes a file that gets rotated monthly
+// Clock provides the current time. It's an interface so that tests can
+// inject a fake clock instead of relying on time.Now().
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock is the Clock used in production; it defers to time.Now().
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now().UTC()
+}
+
+// Config describes how a File is opened and rotated.
+type Config struct {
+	// PathIfShouldRotate decides if and where to rotate to. It's called
+	// before every write with creationTime, the time the currently open file
+	// was created (the zero time if no file is open yet), and now, the
+	// current time. Returning "" means no rotation is needed. Returning a
+	// non-empty path means the current file should be closed and a new file
+	// opened at the returned path.
+	PathIfShouldRotate func(creationTime, now time.Time) string
+
+	// PathFormat is the time.Format pattern used to generate
+	// PathIfShouldRotate's paths. It's only used for retention cleanup, to
+	// find and age rotated files; it's set automatically by NewDaily,
+	// NewHourly and NewMonthly. Leave it empty if PathIfShouldRotate isn't
+	// backed by a single time.Format pattern, which disables cleanup.
+	PathFormat string
+
+	// Clock provides the current time. Defaults to the real wall clock;
+	// override in tests to control when rotation happens.
+	Clock Clock
+
+	// OnClose is an optional function that will be called every time the
+	// existing file is closed, either as a result of calling Close or due to
+	// being rotated. didRotate will be true if it was closed due to rotation.
+	// If OnClose() takes a long time, you should do it in a background
+	// goroutine (it blocks all other operations, including writes).
+	OnClose func(path string, didRotate bool)
+
+	// MaxSize, if positive, is the maximum size in bytes of the currently
+	// open file. Once a Write would push the file past MaxSize, the file is
+	// rotated: renamed to a numbered sibling (see MaxBackups) and a fresh
+	// file is opened at the original path.
+	MaxSize int64
+
+	// MaxLines, if positive, is the maximum number of lines (counted by the
+	// number of '\n' bytes written) the currently open file may hold before
+	// it's rotated the same way as MaxSize.
+	MaxLines int64
+
+	// MaxBackups is the maximum number of numbered backups (path.1, path.2,
+	// ...) kept around after a MaxSize/MaxLines rotation. Older backups are
+	// deleted. Defaults to 1 if MaxSize or MaxLines is set and MaxBackups is
+	// <= 0.
+	//
+	// Retention cleanup (see MaxAge below) also honors MaxBackups as a count
+	// cap on rotated files, but only when MaxBackups is set explicitly
+	// (> 0); it doesn't get the same implicit default of 1, so leaving it
+	// unset while only MaxAge is configured keeps cleanup purely age-based.
+	MaxBackups int
+
+	// MaxAge, if positive, is how long a rotated file is kept around before
+	// retention cleanup deletes it. Requires PathFormat to be set.
+	MaxAge time.Duration
+
+	// Compress, if true, gzips a file to "<path>.gz" as soon as it's
+	// rotated by the PathIfShouldRotate policy, before retention cleanup
+	// considers its age. Numbered backups left by a MaxSize/MaxLines
+	// rotation are not compressed.
+	Compress bool
+
+	// LinkName, if set, is kept as a symlink that always points at the
+	// currently open file, e.g. "/var/log/app/current.log". It's updated
+	// atomically after every successful open. On platforms that can't
+	// create symlinks, LinkName is instead written as a plain text file
+	// containing the path of the currently open file.
+	LinkName string
+}
+
+var _ io.Writer = (*File)(nil)
+
+// File describes a file that gets rotated according to a Config's rotation
+// policy. File implements io.Writer, so it can be passed directly to
+// log.New or plugged into any logging library that accepts an io.Writer.
 type File struct {
 	sync.Mutex
-	pathFormat string
+	cfg Config
 
 	// info about currently opened file
-	year    int
-	month   int
-	path    string
-	file    *os.File
-	onClose func(path string, didRotate bool)
+	creationTime time.Time
+	path         string
+	file         *os.File
+	size         int64
+	lines        int64
 
 	// position in the file of last Write or Write2, exposed for tests
 	lastWritePos int64
+
+	// cleanupErrors carries errors from the background retention cleanup
+	// kicked off after each rotation; see CleanupErrors.
+	cleanupErrors chan error
 }
 
 func (f *File) close(didRotate bool) error {
@@ -38,22 +130,19 @@ func (f *File) close(didRotate bool) error {
 	}
 	err := f.file.Close()
 	f.file = nil
-	if err == nil && f.onClose != nil {
-		f.onClose(f.path, didRotate)
+	if err == nil && f.cfg.OnClose != nil {
+		f.cfg.OnClose(f.path, didRotate)
 	}
-	f.year = 0
-	f.month = 0
+	f.creationTime = time.Time{}
 	return err
 }
 
-func (f *File) open() error {
-	t := time.Now().UTC()
-	f.path = t.Format(f.pathFormat)
-	f.year = t.Year()
-	f.month = int(t.Month())
+func (f *File) open(path string, creationTime time.Time) error {
+	f.path = path
+	f.creationTime = creationTime
 
 	// we can't assume that the dir for the file already exists
-	dir := filepath.Dir(f.path)
+	dir := filepath.Dir(path)
 	err := os.MkdirAll(dir, 0755)
 	if err != nil {
 		return err
@@ -61,42 +150,95 @@ func (f *File) open() error {
 
 	// would be easier to open with os.O_APPEND but Seek() doesn't work in that case
 	flag := os.O_CREATE | os.O_WRONLY
-	f.file, err = os.OpenFile(f.path, flag, 0644)
+	f.file, err = os.OpenFile(path, flag, 0644)
 	if err != nil {
 		return err
 	}
-	_, err = f.file.Seek(0, io.SeekEnd)
-	return err
+	f.size, err = f.file.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	f.lines = 0
+	if f.size > 0 {
+		f.lines, err = countFileLines(path)
+		if err != nil {
+			return err
+		}
+	}
+	return f.updateLink(path)
+}
+
+// countFileLines counts the '\n' bytes in the file at path, so that
+// LineCount/MaxLines stay accurate when open() resumes an existing
+// non-empty file (e.g. after Close then Write, or a process restart
+// mid-period), the same way f.size is resumed via Seek.
+func countFileLines(path string) (int64, error) {
+	r, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, nil
+		}
+		return 0, err
+	}
+	defer r.Close()
+
+	var count int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		count += countLines(buf[:n])
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
 }
 
-// rotate on new day
+// reopenIfNeeded opens the file if it isn't open yet, or closes and reopens
+// it if the rotation policy says it's time to rotate.
 func (f *File) reopenIfNeeded() error {
-	t := time.Now().UTC()
-	if t.Year() == f.year && int(t.Month()) == f.month {
+	now := f.cfg.Clock.Now()
+	newPath := f.cfg.PathIfShouldRotate(f.creationTime, now)
+	if f.file == nil {
+		return f.open(newPath, now)
+	}
+	if newPath == "" {
 		return nil
 	}
+	oldPath := f.path
 	err := f.close(true)
 	if err != nil {
 		return err
 	}
-	return f.open()
+	err = f.open(newPath, now)
+	if err != nil {
+		return err
+	}
+	f.scheduleCleanup(oldPath)
+	return nil
 }
 
-// NewFile creates a new file that will be rotated daily (at UTC midnight).
-// pathFormat is file format accepted by time.Format that will be used to generate
-// a name of the file. It should be unique in a given day e.g. 2006-01-02.txt.
-// onClose is an optional function that will be called every time existing file
-// is closed, either as a result calling Close or due to being rotated.
-// didRotate will be true if it was closed due to rotation.
-// If onClose() takes a long time, you should do it in a background goroutine
-// (it blocks all other operations, including writes)
-func NewFile(pathFormat string, onClose func(path string, didRotate bool)) (*File, error) {
+// New creates a new File using cfg's rotation policy. See NewDaily,
+// NewHourly, and NewMonthly for common policies; call New directly only if
+// those convenience constructors don't cover your rotation schedule.
+func New(cfg Config) (*File, error) {
+	if cfg.PathIfShouldRotate == nil {
+		return nil, errors.New("monthlyrotate: Config.PathIfShouldRotate must be set")
+	}
+	if cfg.Clock == nil {
+		cfg.Clock = realClock{}
+	}
 	f := &File{
-		pathFormat: pathFormat,
+		cfg:           cfg,
+		cleanupErrors: make(chan error, 8),
 	}
 	// force early failure if we can't open the file
-	// note that we don't set onClose yet so that it won't get called due to
+	// note that we don't set OnClose yet so that it won't get called due to
 	// opening/closing the file
+	onClose := f.cfg.OnClose
+	f.cfg.OnClose = nil
 	err := f.reopenIfNeeded()
 	if err != nil {
 		return nil, err
@@ -105,10 +247,99 @@ func NewFile(pathFormat string, onClose func(path string, didRotate bool)) (*Fil
 	if err != nil {
 		return nil, err
 	}
-	f.onClose = onClose
+	f.cfg.OnClose = onClose
 	return f, nil
 }
 
+func monthlyPolicy(pathFormat string) func(creationTime, now time.Time) string {
+	return func(creationTime, now time.Time) string {
+		if !creationTime.IsZero() && creationTime.Year() == now.Year() && creationTime.Month() == now.Month() {
+			return ""
+		}
+		return now.Format(pathFormat)
+	}
+}
+
+func dailyPolicy(pathFormat string) func(creationTime, now time.Time) string {
+	return func(creationTime, now time.Time) string {
+		if !creationTime.IsZero() && creationTime.Year() == now.Year() && creationTime.YearDay() == now.YearDay() {
+			return ""
+		}
+		return now.Format(pathFormat)
+	}
+}
+
+func hourlyPolicy(pathFormat string) func(creationTime, now time.Time) string {
+	return func(creationTime, now time.Time) string {
+		if !creationTime.IsZero() && creationTime.Truncate(time.Hour).Equal(now.Truncate(time.Hour)) {
+			return ""
+		}
+		return now.Format(pathFormat)
+	}
+}
+
+// NewMonthly creates a new file that will be rotated whenever the UTC
+// calendar month changes. pathFormat is a pattern accepted by time.Format
+// that will be used to generate a name of the file. It should be unique in a
+// given month e.g. 2006-01.txt. onClose is an optional function that will be
+// called every time existing file is closed, either as a result calling
+// Close or due to being rotated. didRotate will be true if it was closed due
+// to rotation. If onClose() takes a long time, you should do it in a
+// background goroutine (it blocks all other operations, including writes).
+func NewMonthly(pathFormat string, onClose func(path string, didRotate bool)) (*File, error) {
+	return New(Config{
+		PathIfShouldRotate: monthlyPolicy(pathFormat),
+		PathFormat:         pathFormat,
+		OnClose:            onClose,
+	})
+}
+
+// NewDaily creates a new file that will be rotated whenever the UTC calendar
+// day changes. pathFormat is a pattern accepted by time.Format that will be
+// used to generate a name of the file. It should be unique in a given day
+// e.g. 2006-01-02.txt. onClose is an optional function that will be called
+// every time existing file is closed, either as a result calling Close or
+// due to being rotated. didRotate will be true if it was closed due to
+// rotation. If onClose() takes a long time, you should do it in a background
+// goroutine (it blocks all other operations, including writes).
+func NewDaily(pathFormat string, onClose func(path string, didRotate bool)) (*File, error) {
+	return New(Config{
+		PathIfShouldRotate: dailyPolicy(pathFormat),
+		PathFormat:         pathFormat,
+		OnClose:            onClose,
+	})
+}
+
+// NewHourly creates a new file that will be rotated whenever the UTC hour
+// changes. pathFormat is a pattern accepted by time.Format that will be used
+// to generate a name of the file. It should be unique in a given hour e.g.
+// 2006-01-02-15.txt. onClose is an optional function that will be called
+// every time existing file is closed, either as a result calling Close or
+// due to being rotated. didRotate will be true if it was closed due to
+// rotation. If onClose() takes a long time, you should do it in a background
+// goroutine (it blocks all other operations, including writes).
+func NewHourly(pathFormat string, onClose func(path string, didRotate bool)) (*File, error) {
+	return New(Config{
+		PathIfShouldRotate: hourlyPolicy(pathFormat),
+		PathFormat:         pathFormat,
+		OnClose:            onClose,
+	})
+}
+
+// NewFile creates a new file that will be rotated monthly (at UTC midnight).
+// pathFormat is file format accepted by time.Format that will be used to generate
+// a name of the file. It should be unique in a given month e.g. 2006-01.txt.
+// onClose is an optional function that will be called every time existing file
+// is closed, either as a result calling Close or due to being rotated.
+// didRotate will be true if it was closed due to rotation.
+// If onClose() takes a long time, you should do it in a background goroutine
+// (it blocks all other operations, including writes)
+//
+// NewFile is a thin wrapper over NewMonthly, kept for backward compatibility.
+func NewFile(pathFormat string, onClose func(path string, didRotate bool)) (*File, error) {
+	return NewMonthly(pathFormat, onClose)
+}
+
 // Close closes the file
 func (f *File) Close() error {
 	f.Lock()
@@ -121,6 +352,12 @@ func (f *File) write(d []byte, flush bool) (int64, int, error) {
 	if err != nil {
 		return 0, 0, err
 	}
+	if f.shouldRotateForSize(d) {
+		err = f.rotateForSize()
+		if err != nil {
+			return 0, 0, err
+		}
+	}
 	f.lastWritePos, err = f.file.Seek(0, io.SeekCurrent)
 	if err != nil {
 		return 0, 0, err
@@ -129,12 +366,101 @@ func (f *File) write(d []byte, flush bool) (int64, int, error) {
 	if err != nil {
 		return 0, n, err
 	}
+	f.size += int64(n)
+	f.lines += countLines(d)
 	if flush {
 		err = f.file.Sync()
 	}
 	return f.lastWritePos, n, err
 }
 
+// shouldRotateForSize reports whether writing d to the currently open file
+// would push it past MaxSize or MaxLines.
+func (f *File) shouldRotateForSize(d []byte) bool {
+	if f.cfg.MaxSize > 0 && f.size+int64(len(d)) > f.cfg.MaxSize {
+		return true
+	}
+	if f.cfg.MaxLines > 0 && f.lines+countLines(d) > f.cfg.MaxLines {
+		return true
+	}
+	return false
+}
+
+// countLines returns the number of lines contained in d, counted by the
+// number of '\n' bytes.
+func countLines(d []byte) int64 {
+	var n int64
+	for _, b := range d {
+		if b == '\n' {
+			n++
+		}
+	}
+	return n
+}
+
+// rotateForSize closes the current file, shifts it and any existing numbered
+// backups (path.N -> path.N+1, dropping anything past MaxBackups), and
+// reopens a fresh file at the original path.
+func (f *File) rotateForSize() error {
+	path := f.path
+	creationTime := f.creationTime
+	err := f.close(true)
+	if err != nil {
+		return err
+	}
+	err = shiftBackups(path, f.cfg.MaxBackups)
+	if err != nil {
+		return err
+	}
+	return f.open(path, creationTime)
+}
+
+// shiftBackups renames path to path.1, after first renaming any existing
+// path.N to path.N+1 (up to maxBackups) and deleting the oldest backup that
+// would fall past maxBackups. The rename happens after the file has already
+// been closed, so this also works on Windows.
+func shiftBackups(path string, maxBackups int) error {
+	if maxBackups <= 0 {
+		maxBackups = 1
+	}
+	oldest := backupPath(path, maxBackups)
+	if _, err := os.Stat(oldest); err == nil {
+		if err := os.Remove(oldest); err != nil {
+			return err
+		}
+	}
+	for n := maxBackups - 1; n >= 1; n-- {
+		src := backupPath(path, n)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		if err := os.Rename(src, backupPath(path, n+1)); err != nil {
+			return err
+		}
+	}
+	return os.Rename(path, backupPath(path, 1))
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Size returns the size, in bytes, written so far to the currently open
+// file.
+func (f *File) Size() int64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.size
+}
+
+// LineCount returns the number of lines written so far to the currently
+// open file.
+func (f *File) LineCount() int64 {
+	f.Lock()
+	defer f.Unlock()
+	return f.lines
+}
+
 // Write writes data to a file
 func (f *File) Write(d []byte) (int, error) {
 	f.Lock()
@@ -0,0 +1,42 @@
+package monthlyrotate
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// updateLink atomically points Config.LinkName at path, the file that was
+// just opened. It creates the link under a temporary name in the same
+// directory, then renames it over the target, so tailers never see a
+// missing or partial link. If LinkName isn't set, it's a no-op.
+//
+// If the platform doesn't support symlinks, it falls back to writing path
+// into a plain text file at LinkName.
+func (f *File) updateLink(path string) error {
+	linkName := f.cfg.LinkName
+	if linkName == "" {
+		return nil
+	}
+
+	dir := filepath.Dir(linkName)
+	tmp := filepath.Join(dir, "."+filepath.Base(linkName)+".tmp")
+	os.Remove(tmp)
+
+	target, err := filepath.Rel(dir, path)
+	if err != nil {
+		target = path
+	}
+
+	err = os.Symlink(target, tmp)
+	if errors.Is(err, errors.ErrUnsupported) {
+		if err := os.WriteFile(tmp, []byte(path), 0644); err != nil {
+			return err
+		}
+		return os.Rename(tmp, linkName)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, linkName)
+}